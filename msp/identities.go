@@ -0,0 +1,110 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package msp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/crypto/bccsp"
+)
+
+// identity implements Identity
+type identity struct {
+	// id contains the identifier (MSPID and identity identifier) for this instance
+	id *IdentityIdentifier
+
+	// cert contains the x.509 certificate that signs the public key of this instance
+	cert *x509.Certificate
+
+	// pk is the public key of this instance
+	pk bccsp.Key
+
+	// msp represents the MSP that this identity belongs to
+	msp *bccspmsp
+}
+
+func newIdentity(id *IdentityIdentifier, cert *x509.Certificate, pk bccsp.Key, msp *bccspmsp) Identity {
+	return &identity{id: id, cert: cert, pk: pk, msp: msp}
+}
+
+// GetIdentifier returns the identifier of that identity
+func (id *identity) GetIdentifier() *IdentityIdentifier {
+	return id.id
+}
+
+// GetMSPIdentifier returns the MSP Id for this instance
+func (id *identity) GetMSPIdentifier() string {
+	return id.id.Mspid
+}
+
+// Validate uses the rules that govern this identity to validate it.
+func (id *identity) Validate() error {
+	return id.msp.Validate(id)
+}
+
+// Verify a signature over some message using this identity as reference
+func (id *identity) Verify(msg []byte, sig []byte) error {
+	valid, err := id.msp.bccsp.Verify(id.pk, sig, msg, nil)
+	if err != nil {
+		return fmt.Errorf("Could not determine the validity of the signature, err %s", err)
+	} else if !valid {
+		return fmt.Errorf("The signature is invalid")
+	}
+
+	return nil
+}
+
+// Serialize converts an identity to bytes
+func (id *identity) Serialize() ([]byte, error) {
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: id.cert.Raw})
+
+	sId := &SerializedIdentity{Mspid: id.id.Mspid, IdBytes: pemBytes}
+	idBytes, err := asn1.Marshal(*sId)
+	if err != nil {
+		return nil, fmt.Errorf("Could not marshal a SerializedIdentity structure for identity %s, err %s", id.id, err)
+	}
+
+	return idBytes, nil
+}
+
+// signingidentity implements SigningIdentity
+type signingidentity struct {
+	// we embed everything from a base identity
+	identity
+
+	// signer corresponds to the object that can produce signatures with this identity's private key
+	signer crypto.Signer
+}
+
+func newSigningIdentity(id *IdentityIdentifier, cert *x509.Certificate, pk bccsp.Key, signer crypto.Signer, msp *bccspmsp) SigningIdentity {
+	return &signingidentity{identity{id: id, cert: cert, pk: pk, msp: msp}, signer}
+}
+
+// Sign the supplied message using this identity's private key
+func (id *signingidentity) Sign(msg []byte) ([]byte, error) {
+	return id.signer.Sign(rand.Reader, msg, nil)
+}
+
+// GetPublicVersion returns the public parts of this identity
+func (id *signingidentity) GetPublicVersion() Identity {
+	return &id.identity
+}
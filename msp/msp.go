@@ -0,0 +1,341 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package msp
+
+import (
+	"errors"
+
+	"github.com/op/go-logging"
+)
+
+var mspLogger = logging.MustGetLogger("msp")
+
+// ErrIdentityRevoked is returned by an MSP's Validate when an identity's
+// certificate is otherwise well-formed and chain-verified but appears on
+// one of the MSP's CRLs, so that callers can distinguish revocation from
+// an expired or unrecognized certificate.
+var ErrIdentityRevoked = errors.New("the identity has been revoked")
+
+// IsWellFormed rejection errors, returned instead of an opaque wrapped
+// error so that a caller routing across many MSPs can cheaply tell why
+// a SerializedIdentity was rejected without string-matching.
+var (
+	// ErrMSPMismatch is returned when a SerializedIdentity's Mspid does
+	// not match the MSP that IsWellFormed was called on
+	ErrMSPMismatch = errors.New("the identity does not belong to this MSP")
+	// ErrMalformedIdentity is returned when a SerializedIdentity's
+	// IdBytes do not decode to a single, parseable CERTIFICATE PEM block
+	// with no trailing bytes or headers
+	ErrMalformedIdentity = errors.New("the identity is not a well-formed CERTIFICATE PEM block")
+	// ErrDisallowedSignatureAlgorithm is returned when a certificate's
+	// signature algorithm is not on the MSP's allow-list
+	ErrDisallowedSignatureAlgorithm = errors.New("the identity's signature algorithm is not allowed")
+	// ErrNonCanonicalSignature is returned when a certificate's ECDSA
+	// signature is not in low-S canonical form
+	ErrNonCanonicalSignature = errors.New("the identity's signature is not in low-S canonical form")
+)
+
+// ProviderType indicates the type of an identity provider
+type ProviderType int
+
+const (
+	// FABRIC identifies an identity provider backed by certificates
+	FABRIC ProviderType = iota
+	// OTHER identifies an as-of-yet unsupported identity provider
+	OTHER
+)
+
+// MSPConfig collects the configuration for an MSP; it is agnostic of
+// the underlying provider implementation, which is selected by Type
+type MSPConfig struct {
+	// Type holds the type of the MSP, e.g. FABRIC
+	Type int32
+	// Config holds the bytes corresponding to the provider-specific
+	// configuration, e.g. for an MSP of FABRIC type this would be a
+	// marshalled FabricMSPConfig
+	Config []byte
+}
+
+// FabricMSPConfig holds the configuration for a fabric MSP
+type FabricMSPConfig struct {
+	// Name holds the identifier of the MSP
+	Name string
+	// Admins is a list of admin certificates
+	Admins [][]byte
+	// RootCerts is a list of root certificates trusted by this MSP
+	RootCerts [][]byte
+	// IntermediateCerts is a list of intermediate certificates that chain
+	// up to one of the root certificates above; identities issued by one
+	// of these intermediates are accepted as long as the chain to a root
+	// verifies
+	IntermediateCerts [][]byte
+	// RevocationList is a list of PEM-encoded X.509 certificate revocation
+	// lists (CRLs); identities whose certificate appears in one of these
+	// CRLs are rejected by Validate
+	RevocationList [][]byte
+	// RejectIdentitiesOnStaleCRL, when true, causes Validate to reject
+	// an identity whose matching CRL has passed its NextUpdate, instead
+	// of the default of logging a warning and continuing to enforce the
+	// stale CRL's revocation list
+	RejectIdentitiesOnStaleCRL bool
+	// SigningIdentity, if present, holds the information needed to
+	// build the default signing identity of this MSP
+	SigningIdentity *SigningIdentityInfo
+	// FabricNodeOUs, if present and enabled, allows identities to be
+	// classified as client/peer/admin based on the organizational unit
+	// of their certificate rather than on the static Admins list
+	FabricNodeOUs *FabricNodeOUs
+	// TlsRootCerts is a list of root certificates trusted for TLS,
+	// distinct from RootCerts which governs signing/identity validation
+	TlsRootCerts [][]byte
+	// TlsIntermediateCerts is a list of intermediate certificates that
+	// chain up to one of the TlsRootCerts above
+	TlsIntermediateCerts [][]byte
+}
+
+// FabricNodeOUs configures OU-based classification of identities
+// belonging to this MSP
+type FabricNodeOUs struct {
+	// Enable activates OU-based classification for this MSP
+	Enable bool
+	// ClientOUIdentifier identifies the OU that marks an identity as a client
+	ClientOUIdentifier *FabricOUIdentifier
+	// PeerOUIdentifier identifies the OU that marks an identity as a peer
+	PeerOUIdentifier *FabricOUIdentifier
+	// AdminOUIdentifier identifies the OU that marks an identity as an admin
+	AdminOUIdentifier *FabricOUIdentifier
+}
+
+// FabricOUIdentifier names an organizational unit together with the CA
+// that is expected to have certified identities bearing it
+type FabricOUIdentifier struct {
+	// Certificate is the PEM-encoded certificate of the CA that certifies
+	// this organizational unit
+	Certificate []byte
+	// OrganizationalUnitIdentifier is the value that must appear in a
+	// certificate's Subject.OrganizationalUnit for it to be classified
+	// under this role
+	OrganizationalUnitIdentifier string
+}
+
+// SigningIdentityInfo represents the configuration information
+// related to the signing identity that an MSP is to use on its
+// behalf when signing
+type SigningIdentityInfo struct {
+	// PublicSigner carries the public information of the signing
+	// identity; for an X.509 provider this is the certificate
+	// describing the public key
+	PublicSigner []byte
+	// PrivateSigner denotes a reference to the private key of the
+	// signing identity
+	PrivateSigner *KeyInfo
+}
+
+// KeyInfo represents a (secret) key that is either already stored
+// in the bccsp's keystore or key material that is to be imported there
+type KeyInfo struct {
+	// KeyIdentifier is the identifier of the key inside the default keystore
+	KeyIdentifier string
+	// KeyMaterial, if present, is the byte representation of the key,
+	// to be used when the key is not already in the bccsp's keystore
+	KeyMaterial []byte
+}
+
+// IdentityIdentifier is a holder for the identifier of a specific
+// identity, namespaced by the identifier of its provider
+type IdentityIdentifier struct {
+	// Mspid represents the identifier of the associated membership
+	// service provider
+	Mspid string
+	// Id is the identifier for an identity within a provider
+	Id string
+}
+
+// SerializedIdentity is a serialized form of an identity, used to
+// transport it across the wire
+type SerializedIdentity struct {
+	// Mspid represents the identifier of the msp this identity belongs to
+	Mspid string
+	// IdBytes contains the identity bytes; for a fabric identity this
+	// is the PEM-encoded certificate
+	IdBytes []byte
+}
+
+// Identity interface defining operations associated to a certificate-based
+// identity
+type Identity interface {
+	// GetIdentifier returns the identifier of this identity
+	GetIdentifier() *IdentityIdentifier
+
+	// GetMSPIdentifier returns the MSP identifier for this instance
+	GetMSPIdentifier() string
+
+	// Validate uses the rules that govern this identity to validate it;
+	// it returns nil if the identity is valid, an error otherwise
+	Validate() error
+
+	// Verify a signature over some message using this identity as reference
+	Verify(msg []byte, sig []byte) error
+
+	// Serialize converts an identity to bytes
+	Serialize() ([]byte, error)
+}
+
+// SigningIdentity is an Identity that can also sign messages on behalf
+// of the entity it represents
+type SigningIdentity interface {
+	Identity
+
+	// Sign the supplied message
+	Sign(msg []byte) ([]byte, error)
+
+	// GetPublicVersion returns the public parts of this identity
+	GetPublicVersion() Identity
+}
+
+// IdentityDeserializer is implemented both by MSPs and by the MSPManager
+// and allows to deserialize an identity
+type IdentityDeserializer interface {
+	// DeserializeIdentity deserializes an identity
+	DeserializeIdentity(serializedIdentity []byte) (Identity, error)
+
+	// IsWellFormed checks that the supplied SerializedIdentity carries a
+	// structurally valid, unambiguous identity for this deserializer,
+	// without performing any cryptographic chain verification. It is
+	// meant to let a caller routing across many MSPs cheaply reject
+	// malformed input before attempting that verification.
+	IsWellFormed(identity *SerializedIdentity) error
+}
+
+// MSP is the minimal interface to be implemented to cover Fabric's needs
+// of a Membership Service Provider
+type MSP interface {
+	IdentityDeserializer
+
+	// Setup the MSP instance according to the supplied configuration
+	Setup(config *MSPConfig) error
+
+	// Reconfig reconfigures this MSP given an opaque configuration message
+	Reconfig(config []byte) error
+
+	// GetType returns the provider type
+	GetType() ProviderType
+
+	// GetIdentifier returns the provider identifier
+	GetIdentifier() (string, error)
+
+	// GetPolicy returns the policy that governs this MSP
+	GetPolicy() string
+
+	// GetDefaultSigningIdentity returns the default signing identity
+	// for this MSP, if one is present
+	GetDefaultSigningIdentity() (SigningIdentity, error)
+
+	// GetSigningIdentity returns the signing identity corresponding
+	// to the supplied identifier
+	GetSigningIdentity(identifier *IdentityIdentifier) (SigningIdentity, error)
+
+	// Validate determines whether the supplied identity is valid
+	// according to this MSP's roots of trust; it returns nil if the
+	// identity is valid, an error otherwise
+	Validate(id Identity) error
+
+	// SatisfiesPrincipal determines whether the supplied identity satisfies
+	// the supplied principal; it returns nil if so, an error otherwise,
+	// including the case where the identity is not valid to begin with
+	SatisfiesPrincipal(id Identity, principal *MSPPrincipal) error
+
+	// GetTLSRootCerts returns the PEM-encoded TLS root certificates
+	// trusted by this MSP, suitable for populating a tls.Config's
+	// RootCAs pool directly. Implementations with no notion of a
+	// TLS-specific trust anchor may return nil.
+	GetTLSRootCerts() [][]byte
+
+	// GetTLSIntermediateCerts returns the PEM-encoded TLS intermediate
+	// certificates trusted by this MSP. Implementations with no notion
+	// of a TLS-specific trust anchor may return nil.
+	GetTLSIntermediateCerts() [][]byte
+}
+
+// MSPManager resolves identities to the MSP that issued them, across a
+// set of MSPs configured together, e.g. the MSPs recognized on a given
+// channel
+type MSPManager interface {
+	IdentityDeserializer
+
+	// Setup initializes this manager with the supplied MSPs; it rejects
+	// a configuration with an empty or duplicate MSP identifier
+	Setup(msps []MSP) error
+
+	// GetMSPs returns the MSPs registered with this manager, indexed by
+	// their identifier
+	GetMSPs() (map[string]MSP, error)
+}
+
+// MSPRole classifies an identity according to the role it plays within
+// an MSP
+type MSPRole int32
+
+const (
+	// MEMBER is any identity recognized by the MSP that holds no other role
+	MEMBER MSPRole = iota
+	// ADMIN is an identity entitled to administer the MSP
+	ADMIN
+	// CLIENT is an identity that submits transactions
+	CLIENT
+	// PEER is an identity that runs the peer process
+	PEER
+)
+
+// MSPPrincipalClassification indicates how the Principal field of an
+// MSPPrincipal is to be interpreted
+type MSPPrincipalClassification int32
+
+const (
+	// ROLE indicates that Principal carries a serialized MSPRole
+	ROLE MSPPrincipalClassification = iota
+	// ORGANIZATION_UNIT indicates that Principal carries a serialized
+	// OrganizationUnit
+	ORGANIZATION_UNIT
+	// IDENTITY indicates that Principal carries the bytes of a specific,
+	// serialized identity
+	IDENTITY
+)
+
+// MSPPrincipal governs access to resources guarded by an MSP; depending
+// on Classification, it represents a role within an MSP, an
+// organizational unit, or one specific identity
+type MSPPrincipal struct {
+	// PrincipalClassification describes how Principal should be interpreted
+	PrincipalClassification MSPPrincipalClassification
+	// Principal completes the principal's definition; depending on
+	// PrincipalClassification this is a serialized MSPRole, a serialized
+	// OrganizationUnit, or a serialized identity
+	Principal []byte
+}
+
+// OrganizationUnit identifies an organizational unit of a given MSP,
+// certified by a given CA, used to satisfy an ORGANIZATION_UNIT principal
+type OrganizationUnit struct {
+	// MspIdentifier is the identifier of the associated MSP
+	MspIdentifier string
+	// OrganizationalUnitIdentifier is the name of the OU within the MSP
+	OrganizationalUnitIdentifier string
+	// CertifiersIdentifier is a hash of the SubjectKeyId of the CA that
+	// certifies this organizational unit
+	CertifiersIdentifier []byte
+}
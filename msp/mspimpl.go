@@ -17,8 +17,12 @@ limitations under the License.
 package msp
 
 import (
+	"bytes"
+	"crypto/elliptic"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
+	"math/big"
 	"time"
 
 	"encoding/pem"
@@ -27,28 +31,82 @@ import (
 
 	"encoding/asn1"
 
+	"encoding/hex"
+
 	"github.com/hyperledger/fabric/core/crypto/bccsp"
 	"github.com/hyperledger/fabric/core/crypto/bccsp/factory"
 	"github.com/hyperledger/fabric/core/crypto/bccsp/signer"
 )
 
+// defaultSignatureAlgorithms is the default allow-list of certificate
+// signature algorithms accepted by IsWellFormed, along with the curve
+// whose order is used to check the signature for low-S canonical form
+var defaultSignatureAlgorithms = map[x509.SignatureAlgorithm]elliptic.Curve{
+	x509.ECDSAWithSHA256: elliptic.P256(),
+	x509.ECDSAWithSHA384: elliptic.P384(),
+}
+
+// ecdsaSignature mirrors the ASN.1 structure of an ECDSA signature as
+// embedded in the Signature field of an X.509 certificate
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
 // This is an instantiation of an MSP that
 // uses BCCSP for its cryptographic primitives.
 type bccspmsp struct {
-	// list of certs we trust
+	// list of certs we trust as roots
 	trustedCerts []Identity
 
+	// list of intermediate certs that chain up to a root we trust
+	intermediateCerts []Identity
+
 	// list of signing identities
 	signer SigningIdentity
 
 	// list of admin identities
 	admins []Identity
 
+	// list of certificate revocation lists we know about, used to
+	// check whether a given identity has been revoked
+	revocationList []*pkix.CertificateList
+
+	// if true, a CRL whose NextUpdate has passed causes Validate to
+	// reject the identities it would otherwise cover instead of the
+	// default of logging a warning and continuing to enforce it
+	rejectIdentitiesOnStaleCRL bool
+
 	// the crypto provider
 	bccsp bccsp.BCCSP
 
 	// the provider identifier for this MSP
 	name string
+
+	// whether this MSP classifies identities by their certificate's OU
+	// rather than by the static admins list
+	ouEnforcement bool
+
+	// the OU identifiers that mark an identity as a client, a peer, or
+	// an admin, respectively, when ouEnforcement is set
+	clientOU, peerOU, adminOU *ouIdentifier
+
+	// PEM-encoded TLS root and intermediate certificates trusted by
+	// this MSP; kept distinct from trustedCerts/intermediateCerts
+	// since TLS handshakes and identity validation use separate trust
+	// anchors
+	tlsRootCerts         [][]byte
+	tlsIntermediateCerts [][]byte
+
+	// signatureAlgorithms is the allow-list of certificate signature
+	// algorithms consulted by IsWellFormed
+	signatureAlgorithms map[x509.SignatureAlgorithm]elliptic.Curve
+}
+
+// ouIdentifier associates an organizational unit name with the hashed
+// identifier of the CA that is expected to have certified it
+type ouIdentifier struct {
+	ou           string
+	certifiersId []byte
 }
 
 // NewBccspMsp returns an MSP instance backed up by a BCCSP
@@ -68,26 +126,84 @@ func NewBccspMsp() (MSP, error) {
 
 	theMsp := &bccspmsp{}
 	theMsp.bccsp = bccsp
+	theMsp.signatureAlgorithms = defaultSignatureAlgorithms
 
 	return theMsp, nil
 }
 
-func (msp *bccspmsp) getIdentityFromConf(idBytes []byte) (Identity, error) {
+// getCertifiersIdentifier returns a stable hash of the SKI of the CA that
+// issued the supplied certificate, so that the Id of the resulting
+// Identity can be derived deterministically instead of hardcoded
+func (msp *bccspmsp) getCertifiersIdentifier(cert *x509.Certificate) ([]byte, error) {
+	if len(cert.AuthorityKeyId) == 0 {
+		return nil, fmt.Errorf("getCertifiersIdentifier error: certificate has no AuthorityKeyId set")
+	}
+
+	return msp.hash(cert.AuthorityKeyId)
+}
+
+// getSubjectKeyIdentifierHash returns a stable hash of the supplied CA
+// certificate's own SKI; matching it against the output of
+// getCertifiersIdentifier for some other certificate tells us whether
+// that certificate was issued by this CA
+func (msp *bccspmsp) getSubjectKeyIdentifierHash(cert *x509.Certificate) ([]byte, error) {
+	if len(cert.SubjectKeyId) == 0 {
+		return nil, fmt.Errorf("getSubjectKeyIdentifierHash error: certificate has no SubjectKeyId set")
+	}
+
+	return msp.hash(cert.SubjectKeyId)
+}
+
+func (msp *bccspmsp) hash(msg []byte) ([]byte, error) {
+	digest, err := msp.bccsp.Hash(msg, &bccsp.SHA256Opts{})
+	if err != nil {
+		return nil, fmt.Errorf("hash error: %s", err)
+	}
+
+	return digest, nil
+}
+
+// newOUIdentifier resolves a FabricOUIdentifier's CA certificate and
+// derives the ouIdentifier used to classify identities against it
+func (msp *bccspmsp) newOUIdentifier(conf *FabricOUIdentifier) (*ouIdentifier, error) {
+	caId, err := msp.getIdentityFromConf(conf.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	certifiersId, err := msp.getSubjectKeyIdentifierHash(caId.(*identity).cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ouIdentifier{ou: conf.OrganizationalUnitIdentifier, certifiersId: certifiersId}, nil
+}
+
+// getCertFromPem decodes and parses a single PEM-encoded certificate
+func getCertFromPem(idBytes []byte) (*x509.Certificate, error) {
 	if idBytes == nil {
-		return nil, fmt.Errorf("getIdentityFromBytes error: nil idBytes")
+		return nil, fmt.Errorf("getCertFromPem error: nil idBytes")
 	}
 
 	// Decode the pem bytes
 	pemCert, _ := pem.Decode(idBytes)
 	if pemCert == nil {
-		return nil, fmt.Errorf("getIdentityFromBytes error: could not decode pem bytes")
+		return nil, fmt.Errorf("getCertFromPem error: could not decode pem bytes")
 	}
 
 	// get a cert
-	var cert *x509.Certificate
 	cert, err := x509.ParseCertificate(pemCert.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("getIdentityFromBytes error: failed to parse x509 cert, err %s", err)
+		return nil, fmt.Errorf("getCertFromPem error: failed to parse x509 cert, err %s", err)
+	}
+
+	return cert, nil
+}
+
+func (msp *bccspmsp) getIdentityFromConf(idBytes []byte) (Identity, error) {
+	cert, err := getCertFromPem(idBytes)
+	if err != nil {
+		return nil, fmt.Errorf("getIdentityFromBytes error: %s", err)
 	}
 
 	// get the public key in the right format
@@ -96,9 +212,14 @@ func (msp *bccspmsp) getIdentityFromConf(idBytes []byte) (Identity, error) {
 		return nil, fmt.Errorf("getIdentityFromBytes error: failed to import certitifacate's public key [%s]", err)
 	}
 
+	certifiersId, err := msp.getCertifiersIdentifier(cert)
+	if err != nil {
+		return nil, fmt.Errorf("getIdentityFromBytes error: %s", err)
+	}
+
 	return newIdentity(&IdentityIdentifier{
 		Mspid: msp.name,
-		Id:    "IDENTITY"}, /* FIXME: not clear where we would get the identifier for this identity */
+		Id:    hex.EncodeToString(certifiersId)},
 		cert, certPubK, msp), nil
 }
 
@@ -127,9 +248,7 @@ func (msp *bccspmsp) getSigningIdentityFromConf(sidInfo *SigningIdentityInfo) (S
 		return nil, fmt.Errorf("getIdentityFromBytes error: Failed initializing CryptoSigner, err %s", err)
 	}
 
-	return newSigningIdentity(&IdentityIdentifier{
-		Mspid: msp.name,
-		Id:    "DEFAULT"}, /* FIXME: not clear where we would get the identifier for this identity */
+	return newSigningIdentity(idPub.GetIdentifier(),
 		idPub.(*identity).cert, idPub.(*identity).pk, peerSigner, msp), nil
 }
 
@@ -174,6 +293,85 @@ func (msp *bccspmsp) Setup(conf1 *MSPConfig) error {
 		msp.trustedCerts[i] = id
 	}
 
+	// make and fill the set of intermediate certs, and make sure each
+	// of them chains up to one of the root certs above. Each intermediate
+	// is only added to opts.Intermediates once it has itself verified, so
+	// a multi-level chain (root -> A -> B) requires conf.IntermediateCerts
+	// to list A before B.
+	msp.intermediateCerts = make([]Identity, len(conf.IntermediateCerts))
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, trustedCert := range msp.trustedCerts {
+		opts.Roots.AddCert(trustedCert.(*identity).cert)
+	}
+	for i, intermediateCert := range conf.IntermediateCerts {
+		id, err := msp.getIdentityFromConf(intermediateCert)
+		if err != nil {
+			return err
+		}
+
+		if _, err := id.(*identity).cert.Verify(opts); err != nil {
+			return fmt.Errorf("Setup error: intermediate certificate does not chain to a trusted root, err %s", err)
+		}
+
+		opts.Intermediates.AddCert(id.(*identity).cert)
+		msp.intermediateCerts[i] = id
+	}
+
+	// parse the set of CRLs, if any
+	msp.revocationList = make([]*pkix.CertificateList, len(conf.RevocationList))
+	for i, crlBytes := range conf.RevocationList {
+		crl, err := x509.ParseCRL(crlBytes)
+		if err != nil {
+			return fmt.Errorf("Could not parse RevocationList, err %s", err)
+		}
+
+		msp.revocationList[i] = crl
+	}
+	msp.rejectIdentitiesOnStaleCRL = conf.RejectIdentitiesOnStaleCRL
+
+	// make and fill the set of TLS root certs, keeping the original PEM
+	// bytes around so that callers can feed them directly into a
+	// tls.Config's RootCAs pool without having to recompute the DER
+	msp.tlsRootCerts = make([][]byte, len(conf.TlsRootCerts))
+	tlsRootCertPool := x509.NewCertPool()
+	for i, trustedCert := range conf.TlsRootCerts {
+		cert, err := getCertFromPem(trustedCert)
+		if err != nil {
+			return fmt.Errorf("Failed to parse TlsRootCerts, err %s", err)
+		}
+
+		msp.tlsRootCerts[i] = trustedCert
+		tlsRootCertPool.AddCert(cert)
+	}
+
+	// make and fill the set of TLS intermediate certs, making sure each
+	// of them chains up to one of the TLS root certs above. As above,
+	// a multi-level TLS chain requires conf.TlsIntermediateCerts to list
+	// its certificates in chain order.
+	msp.tlsIntermediateCerts = make([][]byte, len(conf.TlsIntermediateCerts))
+	tlsOpts := x509.VerifyOptions{
+		Roots:         tlsRootCertPool,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for i, intermediateCert := range conf.TlsIntermediateCerts {
+		cert, err := getCertFromPem(intermediateCert)
+		if err != nil {
+			return fmt.Errorf("Failed to parse TlsIntermediateCerts, err %s", err)
+		}
+
+		if _, err := cert.Verify(tlsOpts); err != nil {
+			return fmt.Errorf("Setup error: TLS intermediate certificate does not chain to a trusted TLS root, err %s", err)
+		}
+
+		tlsOpts.Intermediates.AddCert(cert)
+		msp.tlsIntermediateCerts[i] = intermediateCert
+	}
+
 	// setup the signer (if present)
 	if conf.SigningIdentity != nil {
 		sid, err := msp.getSigningIdentityFromConf(conf.SigningIdentity)
@@ -184,6 +382,32 @@ func (msp *bccspmsp) Setup(conf1 *MSPConfig) error {
 		msp.signer = sid
 	}
 
+	// setup the NodeOU-based classification of identities (if enabled)
+	if conf.FabricNodeOUs != nil && conf.FabricNodeOUs.Enable {
+		msp.ouEnforcement = true
+
+		if conf.FabricNodeOUs.ClientOUIdentifier != nil {
+			msp.clientOU, err = msp.newOUIdentifier(conf.FabricNodeOUs.ClientOUIdentifier)
+			if err != nil {
+				return err
+			}
+		}
+
+		if conf.FabricNodeOUs.PeerOUIdentifier != nil {
+			msp.peerOU, err = msp.newOUIdentifier(conf.FabricNodeOUs.PeerOUIdentifier)
+			if err != nil {
+				return err
+			}
+		}
+
+		if conf.FabricNodeOUs.AdminOUIdentifier != nil {
+			msp.adminOU, err = msp.newOUIdentifier(conf.FabricNodeOUs.AdminOUIdentifier)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -210,6 +434,18 @@ func (msp *bccspmsp) GetPolicy() string {
 	return ""
 }
 
+// GetTLSRootCerts returns the PEM-encoded TLS root certificates trusted
+// by this MSP
+func (msp *bccspmsp) GetTLSRootCerts() [][]byte {
+	return msp.tlsRootCerts
+}
+
+// GetTLSIntermediateCerts returns the PEM-encoded TLS intermediate
+// certificates trusted by this MSP
+func (msp *bccspmsp) GetTLSIntermediateCerts() [][]byte {
+	return msp.tlsIntermediateCerts
+}
+
 // GetDefaultSigningIdentity returns the
 // default signing identity for this MSP (if any)
 func (msp *bccspmsp) GetDefaultSigningIdentity() (SigningIdentity, error) {
@@ -229,6 +465,68 @@ func (msp *bccspmsp) GetSigningIdentity(identifier *IdentityIdentifier) (Signing
 	return nil, nil
 }
 
+// validateCertAgainstCRL checks whether the supplied certificate appears
+// in one of this MSP's known CRLs. It looks up the CRL whose issuer
+// matches the certificate's issuer, verifies that the CRL itself was
+// signed by a trusted root or intermediate certificate, and then scans
+// the CRL's revoked list for the certificate's serial number. It
+// returns ErrIdentityRevoked if the certificate is revoked, so that
+// callers can distinguish revocation from expiry or an unrecognized CA.
+func (msp *bccspmsp) validateCertAgainstCRL(cert *x509.Certificate) error {
+	crlSigners := append(append([]Identity{}, msp.trustedCerts...), msp.intermediateCerts...)
+
+	for _, crl := range msp.revocationList {
+		// find the CRL whose Issuer matches the candidate cert's Issuer;
+		// Issuer is an unmarshalled pkix.RDNSequence, so it has to be
+		// re-marshalled before it can be compared against cert.RawIssuer
+		crlIssuerRaw, err := asn1.Marshal(crl.TBSCertList.Issuer)
+		if err != nil {
+			return fmt.Errorf("validateCertAgainstCRL error: failed marshalling CRL issuer, err %s", err)
+		}
+		if !bytes.Equal(cert.RawIssuer, crlIssuerRaw) {
+			continue
+		}
+
+		// look for the issuing certificate, root or intermediate, so we
+		// can verify the CRL's signature
+		var issuerCert *x509.Certificate
+		for _, v := range crlSigners {
+			candidate := v.(*identity).cert
+			if bytes.Equal(candidate.RawSubject, crlIssuerRaw) {
+				issuerCert = candidate
+				break
+			}
+		}
+		if issuerCert == nil {
+			return fmt.Errorf("validateCertAgainstCRL error: no trusted root or intermediate certificate found for CRL issuer")
+		}
+
+		if err := issuerCert.CheckCRLSignature(crl); err != nil {
+			return fmt.Errorf("validateCertAgainstCRL error: invalid signature on the CRL for this MSP, err %s", err)
+		}
+
+		now := time.Now()
+		if now.Before(crl.TBSCertList.ThisUpdate) {
+			continue
+		}
+		if now.After(crl.TBSCertList.NextUpdate) {
+			if msp.rejectIdentitiesOnStaleCRL {
+				return fmt.Errorf("validateCertAgainstCRL error: CRL issued by %s expired on %s", cert.Issuer, crl.TBSCertList.NextUpdate)
+			}
+
+			mspLogger.Infof("WARNING: CRL issued by %s expired on %s; continuing to enforce it", cert.Issuer, crl.TBSCertList.NextUpdate)
+		}
+
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if cert.SerialNumber.Cmp(revoked.SerialNumber) == 0 {
+				return ErrIdentityRevoked
+			}
+		}
+	}
+
+	return nil
+}
+
 // Validate attempts to determine whether
 // the supplied identity is valid according
 // to this MSP's roots of trust; it returns
@@ -243,36 +541,227 @@ func (msp *bccspmsp) Validate(id Identity) error {
 	// root of trust this MSP has
 	case *identity:
 		opts := x509.VerifyOptions{
-			Roots:       x509.NewCertPool(),
-			CurrentTime: time.Now(),
+			Roots:         x509.NewCertPool(),
+			Intermediates: x509.NewCertPool(),
+			CurrentTime:   time.Now(),
 		}
 
 		for _, v := range msp.trustedCerts {
 			opts.Roots.AddCert(v.(*identity).cert)
 		}
+		for _, v := range msp.intermediateCerts {
+			opts.Intermediates.AddCert(v.(*identity).cert)
+		}
 
 		_, err := id.(*identity).cert.Verify(opts)
 		if err != nil {
 			return fmt.Errorf("The supplied identity is not valid, Verify() returned %s", err)
-		} else {
-			return nil
 		}
+
+		if err := msp.validateCertAgainstCRL(id.(*identity).cert); err != nil {
+			return err
+		}
+
+		return nil
 	default:
 		return fmt.Errorf("Identity type not recognized")
 	}
 }
 
+// certFromIdentity extracts the x.509 certificate backing the supplied
+// Identity. id is expected to be either an *identity or a *signingidentity,
+// the only two concrete types that implement Identity in this package; any
+// other type results in an error rather than a panic, since a caller may
+// legitimately hold a SigningIdentity without having called
+// GetPublicVersion() on it first.
+func certFromIdentity(id Identity) (*x509.Certificate, error) {
+	switch v := id.(type) {
+	case *identity:
+		return v.cert, nil
+	case *signingidentity:
+		return v.cert, nil
+	default:
+		return nil, fmt.Errorf("identity type not recognized")
+	}
+}
+
+// classify determines the MSPRole held by the supplied identity within
+// this MSP. When NodeOU enforcement is enabled, it scans the identity's
+// certificate's organizational units for one that matches a configured
+// OU identifier whose associated CA also matches the identity's issuing
+// CA; a certificate matching more than one of them gets the admin role
+// first, then peer, then client. Otherwise, it falls back to treating
+// any cert found in msp.admins as belonging to an admin.
+func (msp *bccspmsp) classify(id Identity) (MSPRole, error) {
+	cert, err := certFromIdentity(id)
+	if err != nil {
+		return MEMBER, fmt.Errorf("classify error: %s", err)
+	}
+
+	if !msp.ouEnforcement {
+		for _, adm := range msp.admins {
+			if adm.(*identity).cert.Equal(cert) {
+				return ADMIN, nil
+			}
+		}
+
+		return MEMBER, nil
+	}
+
+	certifiersId, err := msp.getCertifiersIdentifier(cert)
+	if err != nil {
+		return MEMBER, err
+	}
+
+	for _, c := range []struct {
+		ouId *ouIdentifier
+		role MSPRole
+	}{{msp.adminOU, ADMIN}, {msp.peerOU, PEER}, {msp.clientOU, CLIENT}} {
+		if c.ouId == nil || !bytes.Equal(certifiersId, c.ouId.certifiersId) {
+			continue
+		}
+		for _, ou := range cert.Subject.OrganizationalUnit {
+			if ou == c.ouId.ou {
+				return c.role, nil
+			}
+		}
+	}
+
+	return MEMBER, nil
+}
+
+// SatisfiesPrincipal determines whether the supplied identity is valid
+// according to this MSP's roots of trust and matches the supplied
+// principal; it returns nil if so, an error otherwise
+func (msp *bccspmsp) SatisfiesPrincipal(id Identity, principal *MSPPrincipal) error {
+	if err := id.Validate(); err != nil {
+		return fmt.Errorf("SatisfiesPrincipal error: identity is not valid, err %s", err)
+	}
+
+	switch principal.PrincipalClassification {
+	case ROLE:
+		var mspRole MSPRole
+		if _, err := asn1.Unmarshal(principal.Principal, &mspRole); err != nil {
+			return fmt.Errorf("SatisfiesPrincipal error: could not unmarshal MSPRole from principal, err %s", err)
+		}
+
+		role, err := msp.classify(id)
+		if err != nil {
+			return fmt.Errorf("SatisfiesPrincipal error: could not classify identity, err %s", err)
+		}
+
+		if role != mspRole {
+			return fmt.Errorf("SatisfiesPrincipal error: identity does not have the requested role %v, it has role %v", mspRole, role)
+		}
+
+		return nil
+	case ORGANIZATION_UNIT:
+		ou := &OrganizationUnit{}
+		if err := json.Unmarshal(principal.Principal, ou); err != nil {
+			return fmt.Errorf("SatisfiesPrincipal error: could not unmarshal OrganizationUnit from principal, err %s", err)
+		}
+
+		if ou.MspIdentifier != msp.name {
+			return fmt.Errorf("SatisfiesPrincipal error: identity belongs to MSP %s, not %s", msp.name, ou.MspIdentifier)
+		}
+
+		cert, err := certFromIdentity(id)
+		if err != nil {
+			return fmt.Errorf("SatisfiesPrincipal error: %s", err)
+		}
+
+		certifiersId, err := msp.getCertifiersIdentifier(cert)
+		if err != nil {
+			return fmt.Errorf("SatisfiesPrincipal error: %s", err)
+		}
+
+		if !bytes.Equal(certifiersId, ou.CertifiersIdentifier) {
+			return fmt.Errorf("SatisfiesPrincipal error: identity was not issued by the CA associated to organizational unit %s", ou.OrganizationalUnitIdentifier)
+		}
+
+		for _, unit := range cert.Subject.OrganizationalUnit {
+			if unit == ou.OrganizationalUnitIdentifier {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("SatisfiesPrincipal error: identity does not belong to organizational unit %s", ou.OrganizationalUnitIdentifier)
+	case IDENTITY:
+		otherId, err := msp.DeserializeIdentity(principal.Principal)
+		if err != nil {
+			return fmt.Errorf("SatisfiesPrincipal error: %s", err)
+		}
+
+		// GetIdentifier().Id is derived from the issuing CA's key and is
+		// shared by every identity that CA issued, so it cannot be used
+		// to tell identities apart; compare the certificates themselves
+		cert, err := certFromIdentity(id)
+		if err != nil {
+			return fmt.Errorf("SatisfiesPrincipal error: %s", err)
+		}
+		otherCert, err := certFromIdentity(otherId)
+		if err != nil {
+			return fmt.Errorf("SatisfiesPrincipal error: %s", err)
+		}
+
+		if !cert.Equal(otherCert) {
+			return fmt.Errorf("SatisfiesPrincipal error: the identities do not match")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("SatisfiesPrincipal error: invalid principal classification %v", principal.PrincipalClassification)
+	}
+}
+
+// IsWellFormed checks that sId carries a single, unambiguous
+// CERTIFICATE PEM block belonging to this MSP, signed with an
+// allow-listed algorithm and, for ECDSA, in low-S canonical form. It
+// performs no cryptographic chain verification, so that a caller
+// routing across many MSPs can cheaply reject malformed input before
+// attempting that verification.
+func (msp *bccspmsp) IsWellFormed(sId *SerializedIdentity) error {
+	if sId.Mspid != msp.name {
+		return ErrMSPMismatch
+	}
+
+	bl, rest := pem.Decode(sId.IdBytes)
+	if bl == nil || len(rest) != 0 || bl.Type != "CERTIFICATE" || len(bl.Headers) != 0 {
+		return ErrMalformedIdentity
+	}
+
+	cert, err := x509.ParseCertificate(bl.Bytes)
+	if err != nil {
+		return ErrMalformedIdentity
+	}
+
+	curve, ok := msp.signatureAlgorithms[cert.SignatureAlgorithm]
+	if !ok {
+		return ErrDisallowedSignatureAlgorithm
+	}
+
+	var sig ecdsaSignature
+	if _, err := asn1.Unmarshal(cert.Signature, &sig); err != nil {
+		return ErrMalformedIdentity
+	}
+
+	halfOrder := new(big.Int).Rsh(curve.Params().N, 1)
+	if sig.S.Cmp(halfOrder) > 0 {
+		return ErrNonCanonicalSignature
+	}
+
+	return nil
+}
+
 // DeserializeIdentity returns an Identity
 // instance that was marshalled to the supplied byte array
 func (msp *bccspmsp) DeserializeIdentity(serializedID []byte) (Identity, error) {
 	mspLogger.Infof("Obtaining identity")
 
-	// FIXME: this is not ideal, because the manager already does this
-	// unmarshalling if we go through it; however the local MSP does
-	// not have a manager and in case it has to deserialize an identity,
-	// it will have to do the whole thing by itself; for now I've left
-	// it this way but we can introduce a local MSP manager and fix it
-	// more nicely
+	// This duplicates the unmarshalling an MSPManager already does before
+	// delegating to deserializeIdentityInternal; it is needed here too
+	// since this MSP may be used directly, without a manager in front of
+	// it, in which case nothing else has unmarshalled serializedID yet.
 
 	// We first deserialize to a SerializedIdentity to get the MSP ID
 	sId := &SerializedIdentity{}
@@ -281,28 +770,34 @@ func (msp *bccspmsp) DeserializeIdentity(serializedID []byte) (Identity, error)
 		return nil, fmt.Errorf("Could not deserialize a SerializedIdentity, err %s", err)
 	}
 
-	// This MSP will always deserialize certs this way
-	bl, _ := pem.Decode(sId.IdBytes)
-	if bl == nil {
-		return nil, fmt.Errorf("Could not decode the PEM structure")
+	// Reject malformed input, or input from another MSP, cheaply and
+	// before attempting any cryptographic verification
+	if err := msp.IsWellFormed(sId); err != nil {
+		return nil, err
 	}
+
+	return msp.deserializeIdentityInternal(sId.IdBytes)
+}
+
+// deserializeIdentityInternal builds an Identity from the PEM-encoded
+// certificate bytes carried inside a SerializedIdentity; it is split out
+// of DeserializeIdentity so that MSPManager, which has already
+// unmarshalled the outer SerializedIdentity to find the target MSP,
+// does not have to unmarshal it a second time.
+func (msp *bccspmsp) deserializeIdentityInternal(idBytes []byte) (Identity, error) {
+	// This MSP will always deserialize certs this way
+	bl, _ := pem.Decode(idBytes)
 	cert, err := x509.ParseCertificate(bl.Bytes)
 	if err != nil {
 		return nil, fmt.Errorf("ParseCertificate failed %s", err)
 	}
 
-	// Now we have the certificate; make sure that its fields
-	// (e.g. the Issuer.OU or the Subject.OU) match with the
-	// MSP id that this MSP has; otherwise it might be an attack
-	// TODO!
-	// TODO!
-	// TODO!
-	// TODO!
-	// We can't do it yet because there is no standardized way
-	// (yet) to encode the MSP ID into the x.509 body of a cert
+	certifiersId, err := msp.getCertifiersIdentifier(cert)
+	if err != nil {
+		return nil, fmt.Errorf("deserializeIdentityInternal error: %s", err)
+	}
 
-	id := &IdentityIdentifier{Mspid: msp.name,
-		Id: "DEFAULT"} // TODO: where should this identifier be obtained from?
+	id := &IdentityIdentifier{Mspid: msp.name, Id: hex.EncodeToString(certifiersId)}
 
 	pub, err := msp.bccsp.KeyImport(cert, &bccsp.X509PublicKeyImportOpts{Temporary: true})
 	if err != nil {
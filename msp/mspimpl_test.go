@@ -0,0 +1,458 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package msp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// genCert creates a self-signed certificate (parent == nil) or a
+// certificate issued by parent/parentKey, with the supplied OUs, and
+// returns both the parsed certificate and its PEM encoding.
+func genCert(t *testing.T, cn string, ou []string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, []byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("genCert: failed generating key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject: pkix.Name{
+			CommonName:         cn,
+			OrganizationalUnit: ou,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		SubjectKeyId:          []byte(cn),
+		BasicConstraintsValid: true,
+	}
+
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent, parentKey
+		template.AuthorityKeyId = parent.SubjectKeyId
+	} else {
+		template.IsCA = true
+		template.AuthorityKeyId = template.SubjectKeyId
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("genCert: failed creating certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("genCert: failed parsing certificate: %s", err)
+	}
+
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key
+}
+
+// setupNodeOUMSP builds a bccspmsp that trusts caPEM as a root and
+// classifies identities using the supplied NodeOU configuration.
+func setupNodeOUMSP(t *testing.T, caPEM []byte, nodeOUs *FabricNodeOUs) *bccspmsp {
+	mspI, err := NewBccspMsp()
+	if err != nil {
+		t.Fatalf("setupNodeOUMSP: NewBccspMsp failed: %s", err)
+	}
+	msp := mspI.(*bccspmsp)
+	msp.name = "testMSP"
+	msp.trustedCerts = []Identity{}
+
+	rootId, err := msp.getIdentityFromConf(caPEM)
+	if err != nil {
+		t.Fatalf("setupNodeOUMSP: getIdentityFromConf failed: %s", err)
+	}
+	msp.trustedCerts = append(msp.trustedCerts, rootId)
+
+	msp.ouEnforcement = true
+	if nodeOUs.ClientOUIdentifier != nil {
+		ou, err := msp.newOUIdentifier(nodeOUs.ClientOUIdentifier)
+		if err != nil {
+			t.Fatalf("setupNodeOUMSP: newOUIdentifier(client) failed: %s", err)
+		}
+		msp.clientOU = ou
+	}
+	if nodeOUs.PeerOUIdentifier != nil {
+		ou, err := msp.newOUIdentifier(nodeOUs.PeerOUIdentifier)
+		if err != nil {
+			t.Fatalf("setupNodeOUMSP: newOUIdentifier(peer) failed: %s", err)
+		}
+		msp.peerOU = ou
+	}
+	if nodeOUs.AdminOUIdentifier != nil {
+		ou, err := msp.newOUIdentifier(nodeOUs.AdminOUIdentifier)
+		if err != nil {
+			t.Fatalf("setupNodeOUMSP: newOUIdentifier(admin) failed: %s", err)
+		}
+		msp.adminOU = ou
+	}
+
+	return msp
+}
+
+func TestClassifyNodeOUDispatch(t *testing.T) {
+	caCert, caPEM, caKey := genCert(t, "ca", nil, 1, nil, nil)
+
+	nodeOUs := &FabricNodeOUs{
+		Enable:             true,
+		ClientOUIdentifier: &FabricOUIdentifier{Certificate: caPEM, OrganizationalUnitIdentifier: "client"},
+		PeerOUIdentifier:   &FabricOUIdentifier{Certificate: caPEM, OrganizationalUnitIdentifier: "peer"},
+		AdminOUIdentifier:  &FabricOUIdentifier{Certificate: caPEM, OrganizationalUnitIdentifier: "admin"},
+	}
+	msp := setupNodeOUMSP(t, caPEM, nodeOUs)
+
+	cases := []struct {
+		name string
+		ou   string
+		want MSPRole
+	}{
+		{"client", "client", CLIENT},
+		{"peer", "peer", PEER},
+		{"admin", "admin", ADMIN},
+		{"unknown OU falls back to member", "nobody", MEMBER},
+	}
+
+	for i, c := range cases {
+		_, leafPEM, _ := genCert(t, c.name, []string{c.ou}, int64(100+i), caCert, caKey)
+		id, err := msp.getIdentityFromConf(leafPEM)
+		if err != nil {
+			t.Fatalf("%s: getIdentityFromConf failed: %s", c.name, err)
+		}
+
+		role, err := msp.classify(id)
+		if err != nil {
+			t.Fatalf("%s: classify failed: %s", c.name, err)
+		}
+		if role != c.want {
+			t.Errorf("%s: expected role %v, got %v", c.name, c.want, role)
+		}
+	}
+}
+
+func TestClassifyMismatchedIssuingCA(t *testing.T) {
+	matchingCA, matchingCAPEM, matchingCAKey := genCert(t, "matching-ca", nil, 2, nil, nil)
+	_, otherCAPEM, _ := genCert(t, "other-ca", nil, 3, nil, nil)
+
+	nodeOUs := &FabricNodeOUs{
+		Enable:            true,
+		AdminOUIdentifier: &FabricOUIdentifier{Certificate: otherCAPEM, OrganizationalUnitIdentifier: "admin"},
+	}
+	msp := setupNodeOUMSP(t, matchingCAPEM, nodeOUs)
+
+	_, leafPEM, _ := genCert(t, "leaf", []string{"admin"}, 4, matchingCA, matchingCAKey)
+	id, err := msp.getIdentityFromConf(leafPEM)
+	if err != nil {
+		t.Fatalf("getIdentityFromConf failed: %s", err)
+	}
+
+	role, err := msp.classify(id)
+	if err != nil {
+		t.Fatalf("classify failed: %s", err)
+	}
+	if role != MEMBER {
+		t.Errorf("expected identity issued by a different CA to fall back to MEMBER, got %v", role)
+	}
+}
+
+func TestClassifyMultiOUCertificate(t *testing.T) {
+	ca, caPEM, caKey := genCert(t, "ca", nil, 5, nil, nil)
+
+	nodeOUs := &FabricNodeOUs{
+		Enable:             true,
+		ClientOUIdentifier: &FabricOUIdentifier{Certificate: caPEM, OrganizationalUnitIdentifier: "client"},
+		AdminOUIdentifier:  &FabricOUIdentifier{Certificate: caPEM, OrganizationalUnitIdentifier: "admin"},
+	}
+	msp := setupNodeOUMSP(t, caPEM, nodeOUs)
+
+	_, leafPEM, _ := genCert(t, "leaf", []string{"client", "admin"}, 6, ca, caKey)
+	id, err := msp.getIdentityFromConf(leafPEM)
+	if err != nil {
+		t.Fatalf("getIdentityFromConf failed: %s", err)
+	}
+
+	role, err := msp.classify(id)
+	if err != nil {
+		t.Fatalf("classify failed: %s", err)
+	}
+	if role != ADMIN {
+		t.Errorf("expected admin OU to take precedence for a multi-OU certificate, got %v", role)
+	}
+}
+
+// signCRL builds and parses a CRL issued by signerCert/signerKey covering
+// the supplied revoked serial numbers.
+func signCRL(t *testing.T, signerCert *x509.Certificate, signerKey *ecdsa.PrivateKey, revoked []pkix.RevokedCertificate, thisUpdate, nextUpdate time.Time) *pkix.CertificateList {
+	der, err := signerCert.CreateCRL(rand.Reader, signerKey, revoked, thisUpdate, nextUpdate)
+	if err != nil {
+		t.Fatalf("signCRL: CreateCRL failed: %s", err)
+	}
+
+	crl, err := x509.ParseCRL(der)
+	if err != nil {
+		t.Fatalf("signCRL: ParseCRL failed: %s", err)
+	}
+
+	return crl
+}
+
+func TestValidateCertAgainstCRLRevoked(t *testing.T) {
+	caCert, caPEM, caKey := genCert(t, "ca", nil, 1, nil, nil)
+	msp := setupNodeOUMSP(t, caPEM, &FabricNodeOUs{})
+
+	leafCert, _, _ := genCert(t, "leaf", nil, 2, caCert, caKey)
+
+	revoked := []pkix.RevokedCertificate{{SerialNumber: leafCert.SerialNumber, RevocationTime: time.Now()}}
+	crl := signCRL(t, caCert, caKey, revoked, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	msp.revocationList = []*pkix.CertificateList{crl}
+
+	if err := msp.validateCertAgainstCRL(leafCert); err != ErrIdentityRevoked {
+		t.Errorf("expected ErrIdentityRevoked, got %v", err)
+	}
+}
+
+func TestValidateCertAgainstCRLNotRevoked(t *testing.T) {
+	caCert, caPEM, caKey := genCert(t, "ca", nil, 1, nil, nil)
+	msp := setupNodeOUMSP(t, caPEM, &FabricNodeOUs{})
+
+	leafCert, _, _ := genCert(t, "leaf", nil, 2, caCert, caKey)
+
+	revoked := []pkix.RevokedCertificate{{SerialNumber: big.NewInt(999), RevocationTime: time.Now()}}
+	crl := signCRL(t, caCert, caKey, revoked, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	msp.revocationList = []*pkix.CertificateList{crl}
+
+	if err := msp.validateCertAgainstCRL(leafCert); err != nil {
+		t.Errorf("expected leaf certificate not on the CRL to pass, got %s", err)
+	}
+}
+
+func TestValidateCertAgainstCRLIssuedByIntermediate(t *testing.T) {
+	rootCert, rootPEM, rootKey := genCert(t, "root", nil, 1, nil, nil)
+	msp := setupNodeOUMSP(t, rootPEM, &FabricNodeOUs{})
+
+	intermediateCert, intermediatePEM, intermediateKey := genCert(t, "intermediate", nil, 2, rootCert, rootKey)
+	intermediateId, err := msp.getIdentityFromConf(intermediatePEM)
+	if err != nil {
+		t.Fatalf("getIdentityFromConf failed: %s", err)
+	}
+	msp.intermediateCerts = []Identity{intermediateId}
+
+	leafCert, _, _ := genCert(t, "leaf", nil, 3, intermediateCert, intermediateKey)
+
+	revoked := []pkix.RevokedCertificate{{SerialNumber: leafCert.SerialNumber, RevocationTime: time.Now()}}
+	crl := signCRL(t, intermediateCert, intermediateKey, revoked, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	msp.revocationList = []*pkix.CertificateList{crl}
+
+	if err := msp.validateCertAgainstCRL(leafCert); err != ErrIdentityRevoked {
+		t.Errorf("expected ErrIdentityRevoked for a CRL issued by a trusted intermediate, got %v", err)
+	}
+}
+
+func TestValidateCertAgainstCRLStale(t *testing.T) {
+	caCert, caPEM, caKey := genCert(t, "ca", nil, 1, nil, nil)
+	msp := setupNodeOUMSP(t, caPEM, &FabricNodeOUs{})
+	msp.rejectIdentitiesOnStaleCRL = true
+
+	leafCert, _, _ := genCert(t, "leaf", nil, 2, caCert, caKey)
+
+	revoked := []pkix.RevokedCertificate{{SerialNumber: leafCert.SerialNumber, RevocationTime: time.Now()}}
+	crl := signCRL(t, caCert, caKey, revoked, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	msp.revocationList = []*pkix.CertificateList{crl}
+
+	if err := msp.validateCertAgainstCRL(leafCert); err == nil || err == ErrIdentityRevoked {
+		t.Errorf("expected a stale-CRL error, got %v", err)
+	}
+}
+
+// newTestMSP builds a bare bccspmsp with the given name, with no trusted
+// certs or OU configuration; enough for exercising IsWellFormed.
+func newTestMSP(t *testing.T, name string) *bccspmsp {
+	mspI, err := NewBccspMsp()
+	if err != nil {
+		t.Fatalf("newTestMSP: NewBccspMsp failed: %s", err)
+	}
+	msp := mspI.(*bccspmsp)
+	msp.name = name
+	return msp
+}
+
+// genCertWithSigCanonicality self-signs certificates with different
+// serials until it finds one whose ECDSA signature's S value is on the
+// requested side of the curve's half-order; Go's ecdsa signer does not
+// canonicalize S, so on average half of the attempts will be low-S.
+func genCertWithSigCanonicality(t *testing.T, lowS bool) []byte {
+	halfOrder := new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
+	for i := int64(1); i <= 200; i++ {
+		cert, pemBytes, _ := genCert(t, "leaf", nil, i, nil, nil)
+
+		var sig ecdsaSignature
+		if _, err := asn1.Unmarshal(cert.Signature, &sig); err != nil {
+			t.Fatalf("genCertWithSigCanonicality: failed unmarshalling signature: %s", err)
+		}
+
+		if (sig.S.Cmp(halfOrder) <= 0) == lowS {
+			return pemBytes
+		}
+	}
+
+	t.Fatalf("genCertWithSigCanonicality: could not find a certificate with the desired signature canonicality")
+	return nil
+}
+
+func TestIsWellFormedWrongMSP(t *testing.T) {
+	msp := newTestMSP(t, "testMSP")
+	_, leafPEM, _ := genCert(t, "leaf", nil, 1, nil, nil)
+
+	sId := &SerializedIdentity{Mspid: "otherMSP", IdBytes: leafPEM}
+	if err := msp.IsWellFormed(sId); err != ErrMSPMismatch {
+		t.Errorf("expected ErrMSPMismatch, got %v", err)
+	}
+}
+
+func TestIsWellFormedNonCertificateBlock(t *testing.T) {
+	msp := newTestMSP(t, "testMSP")
+	block := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("not a certificate")})
+
+	sId := &SerializedIdentity{Mspid: "testMSP", IdBytes: block}
+	if err := msp.IsWellFormed(sId); err != ErrMalformedIdentity {
+		t.Errorf("expected ErrMalformedIdentity, got %v", err)
+	}
+}
+
+func TestIsWellFormedTrailingBytes(t *testing.T) {
+	msp := newTestMSP(t, "testMSP")
+	_, leafPEM, _ := genCert(t, "leaf", nil, 1, nil, nil)
+
+	sId := &SerializedIdentity{Mspid: "testMSP", IdBytes: append(leafPEM, []byte("trailing")...)}
+	if err := msp.IsWellFormed(sId); err != ErrMalformedIdentity {
+		t.Errorf("expected ErrMalformedIdentity, got %v", err)
+	}
+}
+
+func TestIsWellFormedDisallowedSignatureAlgorithm(t *testing.T) {
+	msp := newTestMSP(t, "testMSP")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating RSA key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "leaf"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &rsaKey.PublicKey, rsaKey)
+	if err != nil {
+		t.Fatalf("failed creating RSA certificate: %s", err)
+	}
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	sId := &SerializedIdentity{Mspid: "testMSP", IdBytes: leafPEM}
+	if err := msp.IsWellFormed(sId); err != ErrDisallowedSignatureAlgorithm {
+		t.Errorf("expected ErrDisallowedSignatureAlgorithm, got %v", err)
+	}
+}
+
+func TestIsWellFormedNonCanonicalSignature(t *testing.T) {
+	msp := newTestMSP(t, "testMSP")
+	leafPEM := genCertWithSigCanonicality(t, false)
+
+	sId := &SerializedIdentity{Mspid: "testMSP", IdBytes: leafPEM}
+	if err := msp.IsWellFormed(sId); err != ErrNonCanonicalSignature {
+		t.Errorf("expected ErrNonCanonicalSignature, got %v", err)
+	}
+}
+
+func TestIsWellFormedHappyPath(t *testing.T) {
+	msp := newTestMSP(t, "testMSP")
+	leafPEM := genCertWithSigCanonicality(t, true)
+
+	sId := &SerializedIdentity{Mspid: "testMSP", IdBytes: leafPEM}
+	if err := msp.IsWellFormed(sId); err != nil {
+		t.Errorf("expected a well-formed identity to pass, got %s", err)
+	}
+}
+
+// TestSatisfiesPrincipalIdentityDistinguishesCerts guards against
+// SatisfiesPrincipal's IDENTITY case matching on GetIdentifier().Id, which
+// is derived from the issuing CA's key and so is shared by every identity
+// that CA issued.
+func TestSatisfiesPrincipalIdentityDistinguishesCerts(t *testing.T) {
+	caCert, caPEM, caKey := genCert(t, "ca", nil, 1, nil, nil)
+	msp := setupNodeOUMSP(t, caPEM, &FabricNodeOUs{})
+
+	_, alicePEM, _ := genCert(t, "alice", nil, 2, caCert, caKey)
+
+	// bob's identity goes through DeserializeIdentity, whose IsWellFormed
+	// check rejects high-S signatures, so keep issuing his certificate
+	// until its signature is low-S
+	halfOrder := new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+	var bobPEM []byte
+	for serial := int64(3); bobPEM == nil && serial <= 200; serial++ {
+		bobCert, pemBytes, _ := genCert(t, "bob", nil, serial, caCert, caKey)
+		var sig ecdsaSignature
+		if _, err := asn1.Unmarshal(bobCert.Signature, &sig); err != nil {
+			t.Fatalf("failed unmarshalling bob's signature: %s", err)
+		}
+		if sig.S.Cmp(halfOrder) <= 0 {
+			bobPEM = pemBytes
+		}
+	}
+	if bobPEM == nil {
+		t.Fatalf("could not issue bob a certificate with a low-S signature")
+	}
+
+	aliceId, err := msp.getIdentityFromConf(alicePEM)
+	if err != nil {
+		t.Fatalf("getIdentityFromConf(alice) failed: %s", err)
+	}
+	bobId, err := msp.getIdentityFromConf(bobPEM)
+	if err != nil {
+		t.Fatalf("getIdentityFromConf(bob) failed: %s", err)
+	}
+
+	if aliceId.GetIdentifier().Id != bobId.GetIdentifier().Id {
+		t.Fatalf("expected alice and bob, issued by the same CA, to share an Id")
+	}
+
+	bobBytes, err := bobId.Serialize()
+	if err != nil {
+		t.Fatalf("bobId.Serialize failed: %s", err)
+	}
+	principal := &MSPPrincipal{PrincipalClassification: IDENTITY, Principal: bobBytes}
+
+	if err := msp.SatisfiesPrincipal(aliceId, principal); err == nil {
+		t.Errorf("expected alice not to satisfy a principal pinned to bob's identity")
+	}
+	if err := msp.SatisfiesPrincipal(bobId, principal); err != nil {
+		t.Errorf("expected bob to satisfy a principal pinned to his own identity, got %s", err)
+	}
+}
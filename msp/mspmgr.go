@@ -0,0 +1,158 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package msp
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"sync"
+)
+
+// mspManagerImpl is the default implementation of MSPManager; it routes
+// DeserializeIdentity and IsWellFormed calls to the MSP named by the
+// Mspid of the SerializedIdentity at hand.
+type mspManagerImpl struct {
+	// lock guards mspsMap, since a single instance returned by
+	// NewMSPManagerForChannel may be Setup again (e.g. on a channel
+	// config update) concurrently with identity lookups
+	lock sync.RWMutex
+
+	// mspsMap holds the MSPs this manager was set up with, indexed by
+	// their identifier
+	mspsMap map[string]MSP
+}
+
+// NewMSPManager returns a new, empty MSPManager; Setup must be called
+// on it before it can be used
+func NewMSPManager() MSPManager {
+	return &mspManagerImpl{}
+}
+
+// channelMSPManagers holds the per-channel MSPManager instances handed
+// out by NewMSPManagerForChannel
+var channelMSPManagers = struct {
+	sync.Mutex
+	m map[string]MSPManager
+}{m: map[string]MSPManager{}}
+
+// NewMSPManagerForChannel returns the MSPManager for the given channel,
+// creating and registering one on first use. This lets peer code
+// resolve identities in the context of a specific channel's membership
+// set without having to thread a manager instance through by hand.
+func NewMSPManagerForChannel(channelID string) MSPManager {
+	channelMSPManagers.Lock()
+	defer channelMSPManagers.Unlock()
+
+	mgr, ok := channelMSPManagers.m[channelID]
+	if !ok {
+		mgr = NewMSPManager()
+		channelMSPManagers.m[channelID] = mgr
+	}
+
+	return mgr
+}
+
+// Setup initializes this manager with the supplied MSPs; it returns an
+// error if any of them has an empty identifier, or if two of them share
+// the same identifier.
+func (mgr *mspManagerImpl) Setup(msps []MSP) error {
+	mspLogger.Infof("Setting up the MSP manager (%d MSPs)", len(msps))
+
+	mspsMap := make(map[string]MSP, len(msps))
+	for _, m := range msps {
+		mspId, err := m.GetIdentifier()
+		if err != nil {
+			return fmt.Errorf("Could not extract the identifier of an MSP, err %s", err)
+		}
+
+		if mspId == "" {
+			return fmt.Errorf("Setup error: found an MSP with an empty identifier")
+		}
+
+		if _, ok := mspsMap[mspId]; ok {
+			return fmt.Errorf("Setup error: found two MSPs with the same identifier %s", mspId)
+		}
+
+		mspsMap[mspId] = m
+	}
+
+	mgr.lock.Lock()
+	mgr.mspsMap = mspsMap
+	mgr.lock.Unlock()
+
+	return nil
+}
+
+// GetMSPs returns a copy of the MSPs registered with this manager,
+// indexed by their identifier; mutating the returned map has no effect
+// on the manager.
+func (mgr *mspManagerImpl) GetMSPs() (map[string]MSP, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	mspsMap := make(map[string]MSP, len(mgr.mspsMap))
+	for k, v := range mgr.mspsMap {
+		mspsMap[k] = v
+	}
+
+	return mspsMap, nil
+}
+
+// getMSP looks up the MSP registered under the supplied identifier
+func (mgr *mspManagerImpl) getMSP(mspId string) (MSP, bool) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	msp, ok := mgr.mspsMap[mspId]
+	return msp, ok
+}
+
+// DeserializeIdentity unmarshals the outer SerializedIdentity once,
+// looks up the target MSP by its Mspid, and delegates the rest of the
+// deserialization to it.
+func (mgr *mspManagerImpl) DeserializeIdentity(serializedIdentity []byte) (Identity, error) {
+	sId := &SerializedIdentity{}
+	if _, err := asn1.Unmarshal(serializedIdentity, sId); err != nil {
+		return nil, fmt.Errorf("Could not deserialize a SerializedIdentity, err %s", err)
+	}
+
+	msp, ok := mgr.getMSP(sId.Mspid)
+	if !ok {
+		return nil, fmt.Errorf("DeserializeIdentity error: no MSP found for identifier %s", sId.Mspid)
+	}
+
+	bccspMsp, ok := msp.(*bccspmsp)
+	if !ok {
+		return nil, fmt.Errorf("DeserializeIdentity error: MSP %s does not support delegated deserialization", sId.Mspid)
+	}
+
+	if err := bccspMsp.IsWellFormed(sId); err != nil {
+		return nil, err
+	}
+
+	return bccspMsp.deserializeIdentityInternal(sId.IdBytes)
+}
+
+// IsWellFormed delegates to the MSP named by the supplied identity
+func (mgr *mspManagerImpl) IsWellFormed(identity *SerializedIdentity) error {
+	msp, ok := mgr.getMSP(identity.Mspid)
+	if !ok {
+		return fmt.Errorf("IsWellFormed error: no MSP found for identifier %s", identity.Mspid)
+	}
+
+	return msp.IsWellFormed(identity)
+}